@@ -0,0 +1,123 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Codec implements the wire encoding used to wrap a context and request
+// parameters (or a result and response metadata) for transmission, and to
+// recover them on the other end. The package-level Encode, Decode,
+// EncodeResponse, and DecodeResponse functions are thin wrappers around
+// DefaultCodec; callers that want a different wire representation (for
+// example, one that omits the wrapper entirely when there is no deadline or
+// metadata to send) can implement Codec and install it in place of
+// DefaultCodec. A Codec is responsible for both directions of traffic, so
+// that a custom encoding applies uniformly to requests and responses
+// instead of only speeding up one side of a call.
+type Codec interface {
+	Encode(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error)
+	Decode(ctx context.Context, method string, req json.RawMessage) (context.Context, json.RawMessage, error)
+
+	EncodeResponse(ctx context.Context, method string, result json.RawMessage) (json.RawMessage, error)
+	DecodeResponse(ctx context.Context, method string, resp json.RawMessage) (context.Context, json.RawMessage, error)
+}
+
+// DefaultCodec is the Codec used by the package-level Encode, Decode,
+// EncodeResponse, and DecodeResponse functions. It writes the wrapper by
+// hand rather than through json.Marshal, to avoid reflection overhead on
+// the common case of a payload-only call with no deadline, metadata, or
+// trace context.
+var DefaultCodec Codec = defaultCodec{}
+
+type defaultCodec struct{}
+
+func (defaultCodec) Encode(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+	c := buildWireContext(ctx, params)
+	return encodeWireContext(c), nil
+}
+
+func (defaultCodec) Decode(ctx context.Context, method string, req json.RawMessage) (context.Context, json.RawMessage, error) {
+	return decode(ctx, method, req)
+}
+
+func (defaultCodec) EncodeResponse(ctx context.Context, method string, result json.RawMessage) (json.RawMessage, error) {
+	c := buildResponseWireContext(ctx, result)
+	return encodeResponseWireContext(c), nil
+}
+
+func (defaultCodec) DecodeResponse(ctx context.Context, method string, resp json.RawMessage) (context.Context, json.RawMessage, error) {
+	return decodeResponse(ctx, method, resp)
+}
+
+// encodeWireContext hand-writes the JSON for c, rather than going through
+// json.Marshal, since the shape of the wrapper is fixed and known in
+// advance. Payload and Metadata are passed through verbatim, since they are
+// already-encoded JSON.
+func encodeWireContext(c wireContext) json.RawMessage {
+	var buf bytes.Buffer
+	buf.Grow(64 + len(c.Payload) + len(c.Metadata))
+
+	buf.WriteString(`{"jctx":"`)
+	buf.WriteString(wireVersion)
+	buf.WriteByte('"')
+
+	if c.Deadline != nil {
+		buf.WriteString(`,"deadline":"`)
+		buf.Write(c.Deadline.UTC().AppendFormat(nil, time.RFC3339Nano))
+		buf.WriteByte('"')
+	}
+	if len(c.Payload) > 0 {
+		buf.WriteString(`,"payload":`)
+		buf.Write(c.Payload)
+	}
+	if len(c.Metadata) > 0 {
+		buf.WriteString(`,"meta":`)
+		buf.Write(c.Metadata)
+	}
+	if c.Trace != "" {
+		buf.WriteString(`,"trace":`)
+		writeJSONString(&buf, c.Trace)
+		if c.TraceState != "" {
+			buf.WriteString(`,"tracestate":`)
+			writeJSONString(&buf, c.TraceState)
+		}
+	}
+	buf.WriteByte('}')
+	return json.RawMessage(buf.Bytes())
+}
+
+// writeJSONString writes s to buf as a JSON string literal. Trace context
+// values are off the hot path, so this uses json.Marshal rather than a
+// hand-rolled escaper.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	bits, _ := json.Marshal(s) // a Go string always marshals without error
+	buf.Write(bits)
+}
+
+// encodeResponseWireContext hand-writes the JSON for c, mirroring
+// encodeWireContext, since the shape of the response wrapper is likewise
+// fixed and known in advance.
+func encodeResponseWireContext(c responseWireContext) json.RawMessage {
+	var buf bytes.Buffer
+	buf.Grow(32 + len(c.Payload) + len(c.Trailer))
+
+	buf.WriteString(`{"jctx":"`)
+	buf.WriteString(wireVersion)
+	buf.WriteByte('"')
+
+	if len(c.Payload) > 0 {
+		buf.WriteString(`,"payload":`)
+		buf.Write(c.Payload)
+	}
+	if len(c.Trailer) > 0 {
+		buf.WriteString(`,"trailer":`)
+		buf.Write(c.Trailer)
+	}
+	buf.WriteByte('}')
+	return json.RawMessage(buf.Bytes())
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// BenchmarkEncode compares DefaultCodec's hand-rolled writer against a
+// plain json.Marshal of the same wireContext, for a payload-only call with
+// no deadline, metadata, or trace context attached.
+func BenchmarkEncode(b *testing.B) {
+	ctx := context.Background()
+	params := json.RawMessage(`{"id":1,"name":"benchmark"}`)
+
+	b.Run("DefaultCodec", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Encode(ctx, "Test.Method", params); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		v := wireVersion
+		for i := 0; i < b.N; i++ {
+			c := wireContext{V: &v, Payload: params}
+			if _, err := json.Marshal(c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDecodeOptionsAdjust(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		opts       DecodeOptions
+		deadline   time.Time
+		wantAdjust bool
+	}{
+		{
+			name:       "no clock skew configured",
+			opts:       DecodeOptions{},
+			deadline:   now.Add(-time.Second),
+			wantAdjust: false,
+		},
+		{
+			name:       "deadline not yet passed",
+			opts:       DecodeOptions{ClockSkew: 5 * time.Second},
+			deadline:   now.Add(time.Second),
+			wantAdjust: false,
+		},
+		{
+			name:       "deadline passed within skew window",
+			opts:       DecodeOptions{ClockSkew: 5 * time.Second, MinTimeout: time.Second},
+			deadline:   now.Add(-2 * time.Second),
+			wantAdjust: true,
+		},
+		{
+			name:       "deadline passed at exactly the skew boundary",
+			opts:       DecodeOptions{ClockSkew: 2 * time.Second},
+			deadline:   now.Add(-2 * time.Second),
+			wantAdjust: false, // late >= ClockSkew is treated as genuinely expired
+		},
+		{
+			name:       "deadline passed well beyond skew window",
+			opts:       DecodeOptions{ClockSkew: time.Second},
+			deadline:   now.Add(-time.Hour),
+			wantAdjust: false,
+		},
+		{
+			name:       "MinTimeout defaults when unset",
+			opts:       DecodeOptions{ClockSkew: 5 * time.Second},
+			deadline:   now.Add(-time.Second),
+			wantAdjust: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, adj := test.opts.adjust(test.deadline)
+			gotAdjust := adj != 0
+			if gotAdjust != test.wantAdjust {
+				t.Fatalf("adjust(%v) adjustment = %v, want %v", test.deadline, gotAdjust, test.wantAdjust)
+			}
+			if !test.wantAdjust {
+				if !got.Equal(test.deadline) {
+					t.Errorf("adjust(%v) = %v, want deadline unchanged", test.deadline, got)
+				}
+				return
+			}
+			minTimeout := test.opts.MinTimeout
+			if minTimeout <= 0 {
+				minTimeout = defaultMinTimeout
+			}
+			if got.Before(time.Now()) {
+				t.Errorf("adjust(%v) = %v, want a deadline in the future", test.deadline, got)
+			}
+			if got.Sub(test.deadline) != adj {
+				t.Errorf("reported adjustment %v does not match got-deadline delta %v", adj, got.Sub(test.deadline))
+			}
+		})
+	}
+}
+
+// TestDecodeWithCancelAdjustsExpiredDeadline checks that DecodeWithCancel
+// wires DecodeOptions.adjust into the context it returns, including
+// recording the adjustment for ClockSkewAdjustment.
+func TestDecodeWithCancelAdjustsExpiredDeadline(t *testing.T) {
+	v := wireVersion
+	dl := time.Now().Add(-time.Second).UTC()
+	c := wireContext{V: &v, Deadline: &dl}
+	req := encodeWireContext(c)
+
+	ctx, _, cancel, err := DecodeWithCancel(context.Background(), "Test.Method", req, DecodeOptions{
+		ClockSkew:  5 * time.Second,
+		MinTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("DecodeWithCancel: %v", err)
+	}
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatal("DecodeWithCancel: no deadline set on returned context")
+	}
+	if adj, ok := ClockSkewAdjustment(ctx); !ok || adj <= 0 {
+		t.Errorf("ClockSkewAdjustment = %v, %v, want a positive adjustment", adj, ok)
+	}
+}
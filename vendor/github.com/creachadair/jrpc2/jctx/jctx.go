@@ -16,10 +16,12 @@
 // The format of the wrapper generated by this package is:
 //
 //    {
-//      "jctx": "1",
-//      "payload":  <original-params>,
-//      "deadline": <rfc-3339-timestamp>,
-//      "meta":     <json-value>
+//      "jctx":       "1",
+//      "payload":    <original-params>,
+//      "deadline":   <rfc-3339-timestamp>,
+//      "meta":       <json-value>,
+//      "trace":      <w3c-traceparent>,
+//      "tracestate": <w3c-tracestate>
 //    }
 //
 // Of these, only the "jctx" marker is required; the others are assumed to be
@@ -30,12 +32,86 @@
 // If the parent context contains a deadline, it is encoded into the wrapper as
 // an RFC 3339 timestamp in UTC, for example "2009-11-10T23:00:00.00000015Z".
 //
+// DecodeWithCancel returns the CancelFunc for the deadline it establishes,
+// so a server can release the timer as soon as the handler returns instead
+// of waiting for the deadline to fire. Its DecodeOptions also let a server
+// compensate for clock skew: a wire deadline that has only just passed (by
+// less than ClockSkew) is treated as now+MinTimeout rather than as an
+// already-expired request.
+//
 // Metadata
 //
-// The jctx.WithMetadata function allows the caller to attach an arbitrary
-// JSON-encoded value to a context. This value will be transmitted over the
-// wire during a JSON-RPC call. The recipient can decode this value from the
-// context using the jctx.UnmarshalMetadata function.
+// The jctx.WithOutgoingMetadata function allows the caller to attach an
+// arbitrary JSON-encoded value to a context. This value will be transmitted
+// over the wire during a JSON-RPC call. The recipient can decode this value
+// from the context using the jctx.UnmarshalIncomingMetadata function.
+//
+// Outgoing and incoming metadata are kept separate so that metadata a
+// server receives on an inbound call is not automatically forwarded to any
+// outbound calls the server makes using the same context. A server that
+// wants to forward metadata must do so explicitly, by reading it with
+// jctx.UnmarshalIncomingMetadata and reattaching it with
+// jctx.WithOutgoingMetadata.
+//
+// WithMetadata and UnmarshalMetadata remain as deprecated aliases for
+// WithOutgoingMetadata and UnmarshalIncomingMetadata, respectively.
+//
+// Structured metadata
+//
+// The Metadata type gives metadata an ergonomic key/value shape, like the
+// multi-value headers used by gRPC and HTTP, instead of requiring callers
+// to define and marshal their own struct. MetadataFromPairs builds a
+// Metadata from alternating key/value arguments, and AppendMetadata adds to
+// the outgoing Metadata already attached to a context. On the receiving
+// side, MetadataValues reads the values for a key out of the incoming
+// metadata. A Metadata value is encoded in the "meta" field as a JSON
+// object of string arrays, e.g. {"authorization": ["Bearer xyz"]}; metadata
+// of any other JSON shape remains valid on the wire, and MetadataValues
+// simply reports no match for it.
+//
+// Tracing
+//
+// The jctx.WithTraceContext function attaches a W3C traceparent/tracestate
+// pair to a context (see https://www.w3.org/TR/trace-context/). Encode
+// writes it into the "trace" and "tracestate" wire fields, and Decode
+// restores it on the server side, so a span created by the server can be
+// linked as a child of the client's span. Trace context is kept separate
+// from the free-form "meta" field so that tracing middleware can read and
+// write it without colliding with application metadata.
+//
+// An adapter onto the OpenTelemetry propagator interface is not vendored
+// here; it belongs in its own module, pulled in only by callers who import
+// the OpenTelemetry SDK, rather than in this package's dependency graph.
+//
+// Wire encoding
+//
+// Encode, Decode, EncodeResponse, and DecodeResponse all go through
+// DefaultCodec, which hand-writes the JSON for the common case to avoid
+// the reflection overhead of json.Marshal on the hot path. Callers that
+// need a different wire representation can implement the Codec interface
+// and install it as DefaultCodec; Codec covers both requests and
+// responses, so a custom encoding applies uniformly instead of only
+// speeding up one side of a call.
+//
+// Response metadata
+//
+// Metadata so far flows only from caller to callee, on the request. A
+// handler can also attach response metadata with SetResponseMetadata; on
+// return, EncodeResponse writes it as a "trailer" alongside the result,
+// and the caller recovers it with ResponseMetadata once DecodeResponse has
+// processed the response. This mirrors gRPC's header/trailer symmetry, and
+// lets a handler report things like rate-limit budgets, cache hit/miss, or
+// deprecation warnings without changing its typed result.
+//
+// The format of the response wrapper is:
+//
+//    {
+//      "jctx":    "1",
+//      "payload": <original-result>,
+//      "trailer": <json-value>
+//    }
+//
+// As with the request wrapper, only the "jctx" marker is required.
 //
 package jctx
 
@@ -59,12 +135,23 @@ type wireContext struct {
 	Deadline *time.Time      `json:"deadline,omitempty"` // encoded in UTC
 	Payload  json.RawMessage `json:"payload,omitempty"`
 	Metadata json.RawMessage `json:"meta,omitempty"`
+
+	Trace      string `json:"trace,omitempty"`      // W3C traceparent
+	TraceState string `json:"tracestate,omitempty"` // W3C tracestate
 }
 
 // Encode encodes the specified context and request parameters for transmission.
 // If a deadline is set on ctx, it is converted to UTC before encoding.
-// If metadata are set on ctx (see jctx.WithMetadata), they are included.
+// If outgoing metadata are set on ctx (see jctx.WithOutgoingMetadata), they
+// are included.
+//
+// Encode is a thin wrapper around DefaultCodec.Encode; see Codec for how to
+// plug in an alternate wire encoding.
 func Encode(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+	return DefaultCodec.Encode(ctx, method, params)
+}
+
+func buildWireContext(ctx context.Context, params json.RawMessage) wireContext {
 	v := wireVersion
 	c := wireContext{V: &v, Payload: params}
 	if dl, ok := ctx.Deadline(); ok {
@@ -72,12 +159,22 @@ func Encode(ctx context.Context, method string, params json.RawMessage) (json.Ra
 		c.Deadline = &utcdl
 	}
 
-	// If there are metadata in the context, attach them.
-	if v := ctx.Value(metadataKey{}); v != nil {
+	// If there is outgoing metadata in the context, attach it. Incoming
+	// metadata received from a prior call is deliberately not consulted
+	// here, so that a server acting as a client does not leak metadata it
+	// received into calls that it makes.
+	if v := ctx.Value(outgoingMetadataKey{}); v != nil {
 		c.Metadata = v.(json.RawMessage)
 	}
 
-	return json.Marshal(c)
+	// If a trace context is attached, propagate it on the wire so the
+	// server can continue the same trace (see WithTraceContext).
+	if tc, ok := TraceContextFromContext(ctx); ok {
+		c.Trace = tc.Traceparent
+		c.TraceState = tc.Tracestate
+	}
+
+	return c
 }
 
 // Decode decodes the specified request message as a context-wrapped request,
@@ -87,54 +184,151 @@ func Encode(ctx context.Context, method string, params json.RawMessage) (json.Ra
 // If the encoded request specifies a deadline, that deadline is set in the
 // context value returned.
 //
-// If the request includes context metadata, they are attached and can be
-// recovered using jctx.UnmarshalMetadata.
+// If the request includes context metadata, it is attached as incoming
+// metadata and can be recovered using jctx.UnmarshalIncomingMetadata.
+//
+// If the request includes a W3C trace context, it is attached and can be
+// recovered using jctx.TraceContextFromContext.
+//
+// Decode is a thin wrapper around DefaultCodec.Decode; see Codec for how to
+// plug in an alternate wire encoding.
+//
+// Deprecated: Use DecodeWithCancel instead. Decode discards the CancelFunc
+// for any deadline it establishes, which leaks the underlying timer until
+// the deadline expires; DecodeWithCancel lets the caller release it as soon
+// as the handler returns.
 func Decode(ctx context.Context, method string, req json.RawMessage) (context.Context, json.RawMessage, error) {
+	return DefaultCodec.Decode(ctx, method, req)
+}
+
+// decode implements DefaultCodec.Decode (and hence Decode) on top of
+// DecodeWithCancel with zero DecodeOptions; it discards the CancelFunc for
+// any deadline it establishes.
+func decode(ctx context.Context, method string, req json.RawMessage) (context.Context, json.RawMessage, error) {
+	ctx, params, cancel, err := DecodeWithCancel(ctx, method, req, DecodeOptions{})
+	_ = cancel // retained only for backward compatibility; see DecodeWithCancel
+	return ctx, params, err
+}
+
+// DecodeWithCancel behaves as Decode, but additionally returns the
+// CancelFunc for the deadline (if any) established on the returned context,
+// so the caller can release the underlying timer once the request has been
+// handled rather than waiting for the deadline to fire. If the request
+// carries no deadline, the returned CancelFunc is a no-op.
+//
+// opts controls clock-skew compensation; see DecodeOptions.
+func DecodeWithCancel(ctx context.Context, method string, req json.RawMessage, opts DecodeOptions) (context.Context, json.RawMessage, context.CancelFunc, error) {
+	noop := func() {}
 	if len(req) == 0 || req[0] != '{' {
-		return ctx, req, nil // an empty message or non-object has no wrapper
+		return ctx, req, noop, nil // an empty message or non-object has no wrapper
 	}
 	var c wireContext
 	if err := json.Unmarshal(req, &c); err != nil || c.V == nil {
-		return ctx, req, nil // fall back assuming an un-wrapped message
+		return ctx, req, noop, nil // fall back assuming an un-wrapped message
 	} else if *c.V != wireVersion {
-		return nil, nil, fmt.Errorf("invalid context version %q", *c.V)
+		return nil, nil, noop, fmt.Errorf("invalid context version %q", *c.V)
 	}
 	if c.Metadata != nil {
-		ctx = context.WithValue(ctx, metadataKey{}, c.Metadata)
+		ctx = context.WithValue(ctx, incomingMetadataKey{}, c.Metadata)
+	}
+	if c.Trace != "" {
+		ctx = WithTraceContext(ctx, TraceContext{Traceparent: c.Trace, Tracestate: c.TraceState})
 	}
+	cancel := context.CancelFunc(noop)
 	if c.Deadline != nil && !c.Deadline.IsZero() {
-		var ignored context.CancelFunc
-		ctx, ignored = context.WithDeadline(ctx, (*c.Deadline).UTC())
-		_ = ignored // the caller cannot use this value
+		dl, adj := opts.adjust(*c.Deadline)
+		if adj != 0 {
+			ctx = context.WithValue(ctx, clockSkewAdjustmentKey{}, adj)
+		}
+		ctx, cancel = context.WithDeadline(ctx, dl.UTC())
 	}
 
-	return ctx, c.Payload, nil
+	return ctx, c.Payload, cancel, nil
 }
 
-type metadataKey struct{}
+// DecodeOptions controls clock-skew compensation performed by
+// DecodeWithCancel when restoring a deadline from the wire.
+type DecodeOptions struct {
+	// ClockSkew bounds how far in the past a wire deadline may fall and
+	// still be treated as clock skew rather than an expired request. If the
+	// deadline has already passed by less than ClockSkew, it is replaced by
+	// now+MinTimeout instead of being honored as-is.
+	ClockSkew time.Duration
 
-// WithMetadata attaches the specified metadata value to the context.  The meta
-// value must support encoding to JSON. In case of error, the original value of
-// ctx is returned along with the error. If meta == nil, the resulting context
-// has no metadata attached; this can be used to remove metadata from a context
-// that has it.
-func WithMetadata(ctx context.Context, meta interface{}) (context.Context, error) {
+	// MinTimeout is the timeout granted when ClockSkew compensation kicks
+	// in. If zero, a small default is used.
+	MinTimeout time.Duration
+}
+
+const defaultMinTimeout = 500 * time.Millisecond
+
+// adjust returns the deadline to install for dl, compensating for clock
+// skew per the options, and the amount of adjustment applied (zero if
+// none).
+func (o DecodeOptions) adjust(dl time.Time) (time.Time, time.Duration) {
+	if o.ClockSkew <= 0 {
+		return dl, 0
+	}
+	now := time.Now()
+	late := now.Sub(dl)
+	if late <= 0 || late >= o.ClockSkew {
+		return dl, 0 // not already expired, or too stale to be mere skew
+	}
+	min := o.MinTimeout
+	if min <= 0 {
+		min = defaultMinTimeout
+	}
+	adjusted := now.Add(min)
+	return adjusted, adjusted.Sub(dl)
+}
+
+// clockSkewAdjustmentKey is the context key under which DecodeWithCancel
+// records how much it adjusted an expired-looking deadline to compensate
+// for clock skew, for debugging and metrics.
+type clockSkewAdjustmentKey struct{}
+
+// ClockSkewAdjustment reports the clock-skew adjustment DecodeWithCancel
+// applied to the deadline on ctx, and whether any adjustment was made.
+func ClockSkewAdjustment(ctx context.Context) (time.Duration, bool) {
+	adj, ok := ctx.Value(clockSkewAdjustmentKey{}).(time.Duration)
+	return adj, ok
+}
+
+// outgoingMetadataKey is the context key under which metadata destined for
+// the wire (to be written by Encode) is stored.
+type outgoingMetadataKey struct{}
+
+// incomingMetadataKey is the context key under which metadata read off the
+// wire (by Decode) is stored. Keeping this distinct from
+// outgoingMetadataKey prevents metadata a server received on an inbound
+// call from leaking into outbound calls the server itself makes using the
+// same context.
+type incomingMetadataKey struct{}
+
+// WithOutgoingMetadata attaches the specified metadata value to the context,
+// to be transmitted by Encode on the next outgoing call made with ctx.  The
+// meta value must support encoding to JSON. In case of error, the original
+// value of ctx is returned along with the error. If meta == nil, the
+// resulting context has no outgoing metadata attached; this can be used to
+// remove metadata from a context that has it.
+func WithOutgoingMetadata(ctx context.Context, meta interface{}) (context.Context, error) {
 	if meta == nil {
 		// Note we explicitly attach a value even if meta == nil, since ctx might
 		// already have metadata so we need to mask it.
-		return context.WithValue(ctx, metadataKey{}, json.RawMessage(nil)), nil
+		return context.WithValue(ctx, outgoingMetadataKey{}, json.RawMessage(nil)), nil
 	}
 	bits, err := json.Marshal(meta)
 	if err != nil {
 		return ctx, err
 	}
-	return context.WithValue(ctx, metadataKey{}, json.RawMessage(bits)), nil
+	return context.WithValue(ctx, outgoingMetadataKey{}, json.RawMessage(bits)), nil
 }
 
-// UnmarshalMetadata decodes the metadata value attached to ctx into meta, or
-// returns ErrNoMetadata if ctx does not have metadata attached.
-func UnmarshalMetadata(ctx context.Context, meta interface{}) error {
-	if v := ctx.Value(metadataKey{}); v != nil {
+// UnmarshalIncomingMetadata decodes the metadata value Decode attached to ctx
+// into meta, or returns ErrNoMetadata if ctx has no incoming metadata
+// attached.
+func UnmarshalIncomingMetadata(ctx context.Context, meta interface{}) error {
+	if v := ctx.Value(incomingMetadataKey{}); v != nil {
 		// If the metadata value is explicitly nil, we should report that there
 		// is no metadata message.
 		if msg := v.(json.RawMessage); msg != nil {
@@ -144,6 +338,113 @@ func UnmarshalMetadata(ctx context.Context, meta interface{}) error {
 	return ErrNoMetadata
 }
 
+// Metadata is a structured, multi-valued representation of jctx metadata,
+// analogous to the key/value metadata carried by a gRPC context. Each key
+// maps to zero or more string values.
+//
+// A Metadata value is marshaled to JSON as an object whose values are
+// arrays of strings, e.g. {"authorization": ["Bearer xyz"]}. Arbitrary JSON
+// values remain valid on the wire (see Encode and Decode); Metadata is
+// simply a convenience for callers who want typed key/value access instead
+// of defining and marshaling their own struct.
+type Metadata map[string][]string
+
+// Get returns the values associated with key, or nil if there are none.
+func (m Metadata) Get(key string) []string { return m[key] }
+
+// Add appends values to the values already associated with key.
+func (m Metadata) Add(key string, values ...string) {
+	m[key] = append(m[key], values...)
+}
+
+// MetadataFromPairs constructs a Metadata value from alternating key/value
+// arguments, e.g. MetadataFromPairs("user", "alice", "trace-id", "123"). It
+// panics if kv has an odd number of elements.
+func MetadataFromPairs(kv ...string) Metadata {
+	if len(kv)%2 != 0 {
+		panic("jctx.MetadataFromPairs: odd number of arguments")
+	}
+	md := make(Metadata, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md.Add(kv[i], kv[i+1])
+	}
+	return md
+}
+
+// AppendMetadata appends values to the outgoing metadata attached to ctx
+// under key, creating the entry if it does not already exist, and returns
+// the updated context. Existing outgoing metadata that is not shaped like a
+// Metadata value (see Metadata) is discarded, since there is no way to
+// merge an arbitrary JSON value with key/value pairs.
+func AppendMetadata(ctx context.Context, key string, values ...string) (context.Context, error) {
+	md, _ := outgoingMetadata(ctx)
+	if md == nil {
+		md = make(Metadata)
+	}
+	md.Add(key, values...)
+	return WithOutgoingMetadata(ctx, md)
+}
+
+// MetadataValues returns the values associated with key in the incoming
+// metadata attached to ctx (see Decode), and reports whether key was
+// present. It returns false if ctx has no incoming metadata, if the
+// incoming metadata is an arbitrary JSON value that does not have the shape
+// of a Metadata map, or if the Metadata map has no entry for key.
+func MetadataValues(ctx context.Context, key string) ([]string, bool) {
+	md, ok := incomingMetadata(ctx)
+	if !ok {
+		return nil, false
+	}
+	v, ok := md[key]
+	return v, ok
+}
+
+// outgoingMetadata reports the outgoing metadata attached to ctx as a
+// Metadata value, decoding only when the raw JSON has that shape.
+func outgoingMetadata(ctx context.Context) (Metadata, bool) {
+	v, _ := ctx.Value(outgoingMetadataKey{}).(json.RawMessage)
+	return decodeMetadataShape(v)
+}
+
+// incomingMetadata reports the incoming metadata attached to ctx as a
+// Metadata value, decoding only when the raw JSON has that shape.
+func incomingMetadata(ctx context.Context) (Metadata, bool) {
+	v, _ := ctx.Value(incomingMetadataKey{}).(json.RawMessage)
+	return decodeMetadataShape(v)
+}
+
+// decodeMetadataShape attempts to unmarshal msg as a Metadata value,
+// reporting false without error if msg is empty or is valid JSON of some
+// other shape (e.g. a caller-defined struct passed to WithOutgoingMetadata).
+func decodeMetadataShape(msg json.RawMessage) (Metadata, bool) {
+	if len(msg) == 0 {
+		return nil, false
+	}
+	var md Metadata
+	if err := json.Unmarshal(msg, &md); err != nil {
+		return nil, false
+	}
+	return md, true
+}
+
+// WithMetadata attaches the specified metadata value to the context.
+//
+// Deprecated: Use WithOutgoingMetadata instead. WithMetadata is retained
+// for compatibility and is now a thin shim over WithOutgoingMetadata.
+func WithMetadata(ctx context.Context, meta interface{}) (context.Context, error) {
+	return WithOutgoingMetadata(ctx, meta)
+}
+
+// UnmarshalMetadata decodes the metadata value attached to ctx into meta, or
+// returns ErrNoMetadata if ctx does not have metadata attached.
+//
+// Deprecated: Use UnmarshalIncomingMetadata instead. UnmarshalMetadata is
+// retained for compatibility and is now a thin shim over
+// UnmarshalIncomingMetadata.
+func UnmarshalMetadata(ctx context.Context, meta interface{}) error {
+	return UnmarshalIncomingMetadata(ctx, meta)
+}
+
 // ErrNoMetadata is returned by the UnmarshalMetadata function if the context
 // does not contain a metadata value.
 var ErrNoMetadata = errors.New("context metadata not present")
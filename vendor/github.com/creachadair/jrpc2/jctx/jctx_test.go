@@ -0,0 +1,52 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// TestIncomingDoesNotLeakToOutgoing is a regression test for the
+// outgoing/incoming metadata split: metadata a server received on an
+// inbound call (and thus attached as incoming) must not be picked up by
+// Encode on a subsequent outbound call made with the same context, unless
+// the caller explicitly re-attaches it with WithOutgoingMetadata.
+func TestIncomingDoesNotLeakToOutgoing(t *testing.T) {
+	ctx, err := WithOutgoingMetadata(context.Background(), map[string]string{"auth": "secret"})
+	if err != nil {
+		t.Fatalf("WithOutgoingMetadata: %v", err)
+	}
+	req, err := Encode(ctx, "In", json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("Encode inbound request with metadata: %v", err)
+	}
+
+	// Simulate a server receiving the request: it now has incoming metadata.
+	serverCtx, _, err := Decode(context.Background(), "In", req)
+	if err != nil {
+		t.Fatalf("Decode inbound request: %v", err)
+	}
+	var got map[string]string
+	if err := UnmarshalIncomingMetadata(serverCtx, &got); err != nil {
+		t.Fatalf("UnmarshalIncomingMetadata: %v", err)
+	}
+	if got["auth"] != "secret" {
+		t.Fatalf("incoming metadata = %v, want auth=secret", got)
+	}
+
+	// The server now makes an outbound call using the same context. The
+	// metadata it received must not be forwarded automatically.
+	out, err := Encode(serverCtx, "Out", json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("Encode outbound request: %v", err)
+	}
+	outCtx, _, err := Decode(context.Background(), "Out", out)
+	if err != nil {
+		t.Fatalf("Decode outbound request: %v", err)
+	}
+	if err := UnmarshalIncomingMetadata(outCtx, &got); err != ErrNoMetadata {
+		t.Fatalf("UnmarshalIncomingMetadata on forwarded call = %v, want ErrNoMetadata (leak detected)", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// responseWireContext is the encoded representation of a response-side
+// context value. It mirrors wireContext, but carries a trailer of response
+// metadata set by the handler rather than a deadline or request metadata
+// set by the caller.
+type responseWireContext struct {
+	V *string `json:"jctx"` // must be wireVersion
+
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Trailer json.RawMessage `json:"trailer,omitempty"`
+}
+
+// EncodeResponse encodes the specified context and result for transmission
+// as a JSON-RPC response. If response metadata are set on ctx (see
+// SetResponseMetadata), they are included as the response trailer.
+//
+// EncodeResponse is a thin wrapper around DefaultCodec.EncodeResponse; see
+// Codec for how to plug in an alternate wire encoding.
+func EncodeResponse(ctx context.Context, method string, result json.RawMessage) (json.RawMessage, error) {
+	return DefaultCodec.EncodeResponse(ctx, method, result)
+}
+
+func buildResponseWireContext(ctx context.Context, result json.RawMessage) responseWireContext {
+	v := wireVersion
+	c := responseWireContext{V: &v, Payload: result}
+	if v := ctx.Value(responseMetadataKey{}); v != nil {
+		c.Trailer = v.(json.RawMessage)
+	}
+	return c
+}
+
+// DecodeResponse decodes the specified response message as a
+// context-wrapped response, and returns the updated context (based on ctx)
+// and the embedded result. If the response does not have a context
+// wrapper, it is returned as-is.
+//
+// If the response includes a trailer, it is attached to the returned
+// context and can be recovered using ResponseMetadata.
+//
+// DecodeResponse is a thin wrapper around DefaultCodec.DecodeResponse; see
+// Codec for how to plug in an alternate wire encoding.
+func DecodeResponse(ctx context.Context, method string, resp json.RawMessage) (context.Context, json.RawMessage, error) {
+	return DefaultCodec.DecodeResponse(ctx, method, resp)
+}
+
+func decodeResponse(ctx context.Context, method string, resp json.RawMessage) (context.Context, json.RawMessage, error) {
+	if len(resp) == 0 || resp[0] != '{' {
+		return ctx, resp, nil // an empty message or non-object has no wrapper
+	}
+	var c responseWireContext
+	if err := json.Unmarshal(resp, &c); err != nil || c.V == nil {
+		return ctx, resp, nil // fall back assuming an un-wrapped message
+	} else if *c.V != wireVersion {
+		return nil, nil, fmt.Errorf("invalid context version %q", *c.V)
+	}
+	if c.Trailer != nil {
+		ctx = context.WithValue(ctx, responseTrailerKey{}, c.Trailer)
+	}
+	return ctx, c.Payload, nil
+}
+
+// responseMetadataKey is the context key under which a handler attaches
+// response metadata via SetResponseMetadata, to be written into the
+// response trailer by EncodeResponse.
+type responseMetadataKey struct{}
+
+// responseTrailerKey is the context key under which DecodeResponse
+// attaches a trailer read off the wire, to be recovered by
+// ResponseMetadata.
+type responseTrailerKey struct{}
+
+// SetResponseMetadata attaches the specified metadata value to ctx, to be
+// written into the response trailer by EncodeResponse once the handler
+// returns. The meta value must support encoding to JSON. This lets a
+// handler return out-of-band information -- rate-limit budgets remaining,
+// cache hit/miss, server version, deprecation warnings -- without
+// polluting the typed result, the same way gRPC handlers use response
+// trailers.
+func SetResponseMetadata(ctx context.Context, meta interface{}) (context.Context, error) {
+	if meta == nil {
+		return context.WithValue(ctx, responseMetadataKey{}, json.RawMessage(nil)), nil
+	}
+	bits, err := json.Marshal(meta)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, responseMetadataKey{}, json.RawMessage(bits)), nil
+}
+
+// ResponseMetadata decodes the trailer DecodeResponse attached to ctx into
+// meta, or returns ErrNoMetadata if ctx carries no response trailer.
+func ResponseMetadata(ctx context.Context, meta interface{}) error {
+	if v := ctx.Value(responseTrailerKey{}); v != nil {
+		if msg := v.(json.RawMessage); msg != nil {
+			return json.Unmarshal(msg, meta)
+		}
+	}
+	return ErrNoMetadata
+}
@@ -0,0 +1,38 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// TestResponseTrailerRoundTrip exercises SetResponseMetadata, EncodeResponse,
+// DecodeResponse, and ResponseMetadata together.
+func TestResponseTrailerRoundTrip(t *testing.T) {
+	ctx, err := SetResponseMetadata(context.Background(), map[string]string{"cache": "hit"})
+	if err != nil {
+		t.Fatalf("SetResponseMetadata: %v", err)
+	}
+	result := json.RawMessage(`{"ok":true}`)
+	wire, err := EncodeResponse(ctx, "Test.Method", result)
+	if err != nil {
+		t.Fatalf("EncodeResponse: %v", err)
+	}
+
+	gotCtx, gotResult, err := DecodeResponse(context.Background(), "Test.Method", wire)
+	if err != nil {
+		t.Fatalf("DecodeResponse: %v", err)
+	}
+	if string(gotResult) != string(result) {
+		t.Errorf("result = %s, want %s", gotResult, result)
+	}
+	var trailer map[string]string
+	if err := ResponseMetadata(gotCtx, &trailer); err != nil {
+		t.Fatalf("ResponseMetadata: %v", err)
+	}
+	if trailer["cache"] != "hit" {
+		t.Errorf("trailer = %v, want cache=hit", trailer)
+	}
+}
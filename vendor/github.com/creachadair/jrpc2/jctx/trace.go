@@ -0,0 +1,33 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import "context"
+
+// TraceContext holds a W3C trace context, propagated through jctx alongside
+// deadlines and metadata. See https://www.w3.org/TR/trace-context/.
+type TraceContext struct {
+	// Traceparent is the value of the W3C "traceparent" header, for example
+	// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+	Traceparent string
+
+	// Tracestate is the value of the W3C "tracestate" header. It is optional
+	// and may be empty.
+	Tracestate string
+}
+
+// traceContextKey is the context key under which a TraceContext is stored.
+type traceContextKey struct{}
+
+// WithTraceContext attaches tc to ctx, to be transmitted by Encode on the
+// next outgoing call made with ctx. It returns the updated context.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext reports the TraceContext attached to ctx, either
+// by WithTraceContext or by Decode, and whether one was present.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jctx
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestEncodeDecodeRoundTrip exercises Encode/Decode together, checking that
+// a deadline, outgoing metadata, and a trace context all survive the round
+// trip from client to server.
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	deadline := time.Now().Add(time.Minute).UTC().Round(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	ctx, err := WithOutgoingMetadata(ctx, map[string]string{"user": "alice"})
+	if err != nil {
+		t.Fatalf("WithOutgoingMetadata: %v", err)
+	}
+	ctx = WithTraceContext(ctx, TraceContext{
+		Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Tracestate:  "vendor=value",
+	})
+
+	params := json.RawMessage(`{"id":1}`)
+	wire, err := Encode(ctx, "Test.Method", params)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	gotCtx, gotParams, err := Decode(context.Background(), "Test.Method", wire)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if string(gotParams) != string(params) {
+		t.Errorf("payload = %s, want %s", gotParams, params)
+	}
+	if dl, ok := gotCtx.Deadline(); !ok || !dl.Equal(deadline) {
+		t.Errorf("deadline = %v, %v, want %v, true", dl, ok, deadline)
+	}
+	var meta map[string]string
+	if err := UnmarshalIncomingMetadata(gotCtx, &meta); err != nil {
+		t.Fatalf("UnmarshalIncomingMetadata: %v", err)
+	}
+	if meta["user"] != "alice" {
+		t.Errorf("metadata = %v, want user=alice", meta)
+	}
+	tc, ok := TraceContextFromContext(gotCtx)
+	if !ok {
+		t.Fatal("TraceContextFromContext: no trace context after Decode")
+	}
+	if tc.Traceparent != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" || tc.Tracestate != "vendor=value" {
+		t.Errorf("trace context = %+v, want traceparent/tracestate preserved", tc)
+	}
+}